@@ -0,0 +1,151 @@
+package ovn
+
+import (
+	"fmt"
+
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/types"
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/util"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// defaultClientIPAffinityTimeoutSeconds matches the Kubernetes default
+// (ClientIP session affinity with no explicit timeout configured).
+const defaultClientIPAffinityTimeoutSeconds = int32(10800)
+
+// affinitySpec is the OVN-facing projection of a Service's SessionAffinity.
+type affinitySpec struct {
+	Enabled        bool
+	TimeoutSeconds int32
+}
+
+// getAffinitySpec derives the affinity OVN should apply for svc from
+// svc.Spec.SessionAffinity / SessionAffinityConfig.
+func getAffinitySpec(svc *kapi.Service) affinitySpec {
+	if svc.Spec.SessionAffinity != kapi.ServiceAffinityClientIP {
+		return affinitySpec{}
+	}
+	timeout := defaultClientIPAffinityTimeoutSeconds
+	if cfg := svc.Spec.SessionAffinityConfig; cfg != nil && cfg.ClientIP != nil && cfg.ClientIP.TimeoutSeconds != nil {
+		timeout = *cfg.ClientIP.TimeoutSeconds
+	}
+	return affinitySpec{Enabled: true, TimeoutSeconds: timeout}
+}
+
+// dedicatedLoadBalancerName names the per-service Load_Balancer row a ClientIP
+// affinity service gets instead of riding the shared per-(protocol, family)
+// cluster LB every other ClusterIP service uses.
+func dedicatedLoadBalancerName(namespace, name string, protocol kapi.Protocol, family kapi.IPFamily) string {
+	return fmt.Sprintf("%s%s_%s_%s_%s", types.ServiceLBPrefix, namespace, name, protocol, family)
+}
+
+// ensureServiceClusterLoadBalancer returns the Load_Balancer row svcPort's
+// ClusterIP VIPs should be programmed against for family: the shared
+// per-(protocol, family) cluster LB when the service doesn't request ClientIP
+// affinity, or a dedicated per-service LB when it does.
+//
+// getLoadBalancer's LB is shared by every ClusterIP service of a (protocol,
+// family); options:affinity_timeout/selection_fields are per-LB-row settings
+// in OVN, so setting them there would turn on source-hash affinity for every
+// other service sharing that row. A dedicated row keeps the setting (and the
+// VIP carrying it) scoped to this one service.
+func (ovn *Controller) ensureServiceClusterLoadBalancer(svc *kapi.Service, protocol kapi.Protocol, family kapi.IPFamily, affinity affinitySpec) (string, error) {
+	if !affinity.Enabled {
+		if err := ovn.deleteDedicatedServiceLoadBalancer(svc, protocol, family); err != nil {
+			klog.Errorf("Failed to clean up dedicated affinity load balancer for svc %s: %v", svc.Name, err)
+		}
+		return ovn.getLoadBalancer(protocol, family)
+	}
+
+	lbName := dedicatedLoadBalancerName(svc.Namespace, svc.Name, protocol, family)
+	lb, stderr, err := util.RunOVNNbctl("--data=bare", "--no-heading", "--columns=_uuid", "find",
+		"load_balancer", fmt.Sprintf("name=%s", lbName))
+	if err != nil {
+		return "", fmt.Errorf("failed to find dedicated load balancer %s: stderr: %q, err: %v", lbName, stderr, err)
+	}
+	if lb == "" {
+		if _, stderr, err := util.RunOVNNbctl("create", "load_balancer", fmt.Sprintf("name=%s", lbName),
+			fmt.Sprintf("protocol=%s", protocol)); err != nil {
+			return "", fmt.Errorf("failed to create dedicated load balancer %s: stderr: %q, err: %v", lbName, stderr, err)
+		}
+	}
+
+	nodes, err := ovn.watchFactory.GetNodes()
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes for dedicated load balancer %s: %v", lbName, err)
+	}
+	for _, node := range nodes {
+		if _, stderr, err := util.RunOVNNbctl("--may-exist", "ls-lb-add", node.Name, lbName); err != nil {
+			klog.Errorf("Failed to attach dedicated load balancer %s to switch %s: stderr: %q, err: %v", lbName, node.Name, stderr, err)
+		}
+	}
+	return lbName, nil
+}
+
+// deleteDedicatedServiceLoadBalancer removes svc's dedicated affinity load
+// balancer, if any, e.g. because it transitioned back to SessionAffinity:
+// None and its VIPs now belong back on the shared cluster LB.
+func (ovn *Controller) deleteDedicatedServiceLoadBalancer(svc *kapi.Service, protocol kapi.Protocol, family kapi.IPFamily) error {
+	lbName := dedicatedLoadBalancerName(svc.Namespace, svc.Name, protocol, family)
+	if _, stderr, err := util.RunOVNNbctl("--if-exists", "destroy", "load_balancer", lbName); err != nil {
+		return fmt.Errorf("failed to remove dedicated load balancer %s: stderr: %q, err: %v", lbName, stderr, err)
+	}
+	return nil
+}
+
+// configureLoadBalancerAffinity sets or clears the affinity_timeout option and
+// source-IP selection_fields on an OVN Load_Balancer row. It is safe to call
+// repeatedly; it only ever touches the LB's affinity settings, never its vips.
+func (ovn *Controller) configureLoadBalancerAffinity(loadBalancer string, affinity affinitySpec) error {
+	if !affinity.Enabled {
+		if _, stderr, err := util.RunOVNNbctl(
+			"--", "--if-exists", "remove", "load_balancer", loadBalancer, "options", "affinity_timeout",
+			"--", "clear", "load_balancer", loadBalancer, "selection_fields",
+		); err != nil {
+			return fmt.Errorf("failed to clear affinity on load balancer %s: stderr: %q, err: %v", loadBalancer, stderr, err)
+		}
+		return nil
+	}
+
+	if _, stderr, err := util.RunOVNNbctl(
+		"set", "load_balancer", loadBalancer,
+		fmt.Sprintf("options:affinity_timeout=%d", affinity.TimeoutSeconds),
+		"selection_fields=ip_src",
+	); err != nil {
+		return fmt.Errorf("failed to set affinity_timeout=%d on load balancer %s: stderr: %q, err: %v",
+			affinity.TimeoutSeconds, loadBalancer, stderr, err)
+	}
+	return nil
+}
+
+// reconcileServiceAffinity applies svc's SessionAffinity to the ClusterIP load
+// balancer(s) that carry its VIPs.
+//
+// It deliberately does NOT touch the gateway/worker load balancers: those are
+// shared across every service landing on that gateway router/worker switch
+// (see getGatewayLoadBalancer/loadbalancer.GetWorkerLoadBalancer), so setting
+// affinity options there would turn on source-hash affinity for unrelated
+// services' VIPs riding the same row. NodePort/ExternalIP/LoadBalancer-ingress
+// VIPs are programmed through the per-node LB endpoint (see lb_endpoint.go)
+// instead of those legacy rows; giving that mechanism the same per-service
+// affinity treatment as the cluster LB below is tracked separately, since it
+// needs its own dedicated-LB-per-service scoping there too.
+func (ovn *Controller) reconcileServiceAffinity(svc *kapi.Service, svcPort kapi.ServicePort) error {
+	affinity := getAffinitySpec(svc)
+
+	for _, family := range []kapi.IPFamily{kapi.IPv4Protocol, kapi.IPv6Protocol} {
+		loadBalancer, err := ovn.ensureServiceClusterLoadBalancer(svc, svcPort.Protocol, family, affinity)
+		if err != nil {
+			klog.Errorf("Error resolving affinity load balancer for svc %s (%s/%s): %v", svc.Name, svcPort.Protocol, family, err)
+			continue
+		}
+		if !affinity.Enabled {
+			continue
+		}
+		if err := ovn.configureLoadBalancerAffinity(loadBalancer, affinity); err != nil {
+			klog.Errorf("Error reconciling affinity for svc %s on %s: %v", svc.Name, loadBalancer, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ovn
+
+import (
+	"testing"
+
+	kapi "k8s.io/api/core/v1"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestGetAffinitySpec(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *kapi.Service
+		want affinitySpec
+	}{
+		{
+			name: "affinity None",
+			svc: &kapi.Service{Spec: kapi.ServiceSpec{
+				SessionAffinity: kapi.ServiceAffinityNone,
+			}},
+			want: affinitySpec{Enabled: false},
+		},
+		{
+			name: "ClientIP with default timeout",
+			svc: &kapi.Service{Spec: kapi.ServiceSpec{
+				SessionAffinity: kapi.ServiceAffinityClientIP,
+			}},
+			want: affinitySpec{Enabled: true, TimeoutSeconds: defaultClientIPAffinityTimeoutSeconds},
+		},
+		{
+			name: "ClientIP with custom timeout",
+			svc: &kapi.Service{Spec: kapi.ServiceSpec{
+				SessionAffinity: kapi.ServiceAffinityClientIP,
+				SessionAffinityConfig: &kapi.SessionAffinityConfig{
+					ClientIP: &kapi.ClientIPConfig{TimeoutSeconds: int32Ptr(60)},
+				},
+			}},
+			want: affinitySpec{Enabled: true, TimeoutSeconds: 60},
+		},
+		{
+			name: "transition back to None clears affinity",
+			svc: &kapi.Service{Spec: kapi.ServiceSpec{
+				SessionAffinity: kapi.ServiceAffinityNone,
+				SessionAffinityConfig: &kapi.SessionAffinityConfig{
+					ClientIP: &kapi.ClientIPConfig{TimeoutSeconds: int32Ptr(60)},
+				},
+			}},
+			want: affinitySpec{Enabled: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getAffinitySpec(tt.svc); got != tt.want {
+				t.Errorf("getAffinitySpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDedicatedLoadBalancerNameIsPerService guards against the cross-service
+// regression this dedicated-LB scheme exists to prevent: two different
+// services (or the same service on different protocols/families) must never
+// resolve to the same Load_Balancer name, or setting affinity for one would
+// silently touch the other's VIPs again.
+func TestDedicatedLoadBalancerNameIsPerService(t *testing.T) {
+	names := map[string]string{
+		"svcA-tcp-v4": dedicatedLoadBalancerName("ns1", "svc-a", kapi.ProtocolTCP, kapi.IPv4Protocol),
+		"svcB-tcp-v4": dedicatedLoadBalancerName("ns1", "svc-b", kapi.ProtocolTCP, kapi.IPv4Protocol),
+		"svcA-udp-v4": dedicatedLoadBalancerName("ns1", "svc-a", kapi.ProtocolUDP, kapi.IPv4Protocol),
+		"svcA-tcp-v6": dedicatedLoadBalancerName("ns1", "svc-a", kapi.ProtocolTCP, kapi.IPv6Protocol),
+		"svcA-ns2":    dedicatedLoadBalancerName("ns2", "svc-a", kapi.ProtocolTCP, kapi.IPv4Protocol),
+	}
+
+	seen := map[string]string{}
+	for key, name := range names {
+		if prior, ok := seen[name]; ok {
+			t.Fatalf("dedicatedLoadBalancerName collision: %q and %q both resolve to %q", prior, key, name)
+		}
+		seen[name] = key
+	}
+}
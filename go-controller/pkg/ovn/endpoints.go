@@ -14,9 +14,27 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// lbEndpoints is a backend set for one (protocol, port name), bucketed by
+// address family so a v4 VIP is never programmed with v6 backends or vice
+// versa, and a v6 VIP is never programmed with v4 backends.
 type lbEndpoints struct {
-	IPs  []string
-	Port int32
+	IPsByFamily map[kapi.IPFamily][]string
+	Port        int32
+}
+
+// IPs returns the backend set for a single address family, or nil if there
+// are none.
+func (e lbEndpoints) IPs(family kapi.IPFamily) []string {
+	return e.IPsByFamily[family]
+}
+
+// ipFamily returns the IPFamily of ip, determined the same way hasHostEndpoints
+// already parses endpoint addresses.
+func ipFamily(ip string) kapi.IPFamily {
+	if net.ParseIP(ip).To4() != nil {
+		return kapi.IPv4Protocol
+	}
+	return kapi.IPv6Protocol
 }
 
 func (ovn *Controller) getLbEndpoints(ep *kapi.Endpoints) map[kapi.Protocol]map[string]lbEndpoints {
@@ -28,17 +46,17 @@ func (ovn *Controller) getLbEndpoints(ep *kapi.Endpoints) map[kapi.Protocol]map[
 	for _, s := range ep.Subsets {
 		for _, ip := range s.Addresses {
 			for _, port := range s.Ports {
-				var ips []string
 				if err := util.ValidatePort(port.Protocol, port.Port); err != nil {
 					klog.Errorf("Invalid endpoint port: %s: %v", port.Name, err)
 					continue
 				}
-				if lbEps, ok := protoPortMap[port.Protocol][port.Name]; ok {
-					ips = append(lbEps.IPs, ip.IP)
-				} else {
-					ips = []string{ip.IP}
+				lbEps, ok := protoPortMap[port.Protocol][port.Name]
+				if !ok {
+					lbEps = lbEndpoints{IPsByFamily: make(map[kapi.IPFamily][]string), Port: port.Port}
 				}
-				protoPortMap[port.Protocol][port.Name] = lbEndpoints{IPs: ips, Port: port.Port}
+				family := ipFamily(ip.IP)
+				lbEps.IPsByFamily[family] = append(lbEps.IPsByFamily[family], ip.IP)
+				protoPortMap[port.Protocol][port.Name] = lbEps
 			}
 		}
 	}
@@ -86,43 +104,59 @@ func (ovn *Controller) AddEndpoints(ep *kapi.Endpoints, addClusterLBs bool) erro
 		}
 
 		if util.ServiceTypeHasNodePort(svc) {
-			if err := ovn.createPerNodeVIPs(nil, svcPort.Protocol, svcPort.NodePort, lbEps.IPs, lbEps.Port); err != nil {
-				klog.Errorf("Error in creating Node Port for svc %s, node port: %d - %v\n", svc.Name, svcPort.NodePort, err)
-				continue
+			// NodePort is exposed on every node address of both families, so
+			// each family gets its own pass with only same-family backends.
+			for family, ips := range lbEps.IPsByFamily {
+				if err := ovn.createPerNodeVIPs(nil, svcPort.Protocol, svcPort.NodePort, ips, lbEps.Port, family); err != nil {
+					klog.Errorf("Error in creating Node Port for svc %s, node port: %d, family: %s - %v\n", svc.Name, svcPort.NodePort, family, err)
+					continue
+				}
 			}
 		}
 
 		if util.ServiceTypeHasClusterIP(svc) {
-			var loadBalancer string
-			loadBalancer, err = ovn.getLoadBalancer(svcPort.Protocol)
-			if err != nil {
-				klog.Errorf("Failed to get load balancer for %s (%v)", svcPort.Protocol, err)
-				continue
+			clusterIPs := svc.Spec.ClusterIPs
+			if len(clusterIPs) == 0 {
+				clusterIPs = []string{svc.Spec.ClusterIP}
 			}
+			for _, clusterIP := range clusterIPs {
+				family := ipFamily(clusterIP)
+				familyIPs := lbEps.IPs(family)
 
-			// If any of the lbEps contain the a host IP we add to worker/GR LB separately, and not to cluster LB
-			if hasHostEndpoints(lbEps.IPs) && config.Gateway.Mode == config.GatewayModeShared {
-				if err := ovn.createPerNodeVIPs([]string{svc.Spec.ClusterIP}, svcPort.Protocol, svcPort.Port, lbEps.IPs, lbEps.Port); err != nil {
-					klog.Errorf("Error in creating Cluster IP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
+				// ClientIP affinity needs its own dedicated load balancer per
+				// service (see affinity.go); every other service rides the
+				// shared per-(protocol, family) cluster LB.
+				loadBalancer, err := ovn.ensureServiceClusterLoadBalancer(svc, svcPort.Protocol, family, getAffinitySpec(svc))
+				if err != nil {
+					klog.Errorf("Failed to get %s load balancer for %s (%v)", family, svcPort.Protocol, err)
 					continue
 				}
-				// Need to ensure that if vip exists on cluster LB we remove it
-				// This can happen if endpoints originally had cluster only ips but now have host ips
-				vip := util.JoinHostPortInt32(svc.Spec.ClusterIP, svcPort.Port)
-				if err := ovn.deleteLoadBalancerVIP(loadBalancer, vip); err != nil {
-					klog.Error(err)
-				}
-			} else if addClusterLBs {
-				if err = ovn.createLoadBalancerVIPs(loadBalancer, []string{svc.Spec.ClusterIP}, svcPort.Port, lbEps.IPs, lbEps.Port); err != nil {
-					klog.Errorf("Error in creating Cluster IP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
-					continue
+
+				// If any of the same-family lbEps contain a host IP we add to worker/GR LB separately, and not to cluster LB
+				if hasHostEndpoints(familyIPs) && config.Gateway.Mode == config.GatewayModeShared {
+					if err := ovn.createPerNodeVIPs([]string{clusterIP}, svcPort.Protocol, svcPort.Port, familyIPs, lbEps.Port, family); err != nil {
+						klog.Errorf("Error in creating Cluster IP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
+						continue
+					}
+					// Need to ensure that if vip exists on cluster LB we remove it
+					// This can happen if endpoints originally had cluster only ips but now have host ips
+					vip := util.JoinHostPortInt32(clusterIP, svcPort.Port)
+					if err := ovn.deleteLoadBalancerVIP(loadBalancer, vip); err != nil {
+						klog.Error(err)
+					}
+				} else if addClusterLBs {
+					if err := ovn.createLoadBalancerVIPs(loadBalancer, []string{clusterIP}, svcPort.Port, familyIPs, lbEps.Port); err != nil {
+						klog.Errorf("Error in creating Cluster IP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
+						continue
+					}
+					// Need to ensure if this vip exists in the worker LBs that we remove it
+					// This can happen if the endpoints originally had host eps but now have cluster only ips
+					ovn.deleteNodeVIPs([]string{clusterIP}, svcPort.Protocol, svcPort.Port, family)
 				}
-				// Need to ensure if this vip exists in the worker LBs that we remove it
-				// This can happen if the endpoints originally had host eps but now have cluster only ips
-				ovn.deleteNodeVIPs([]string{svc.Spec.ClusterIP}, svcPort.Protocol, svcPort.Port)
 			}
-			if len(svc.Spec.ExternalIPs) > 0 {
-				if err := ovn.createPerNodeVIPs(svc.Spec.ExternalIPs, svcPort.Protocol, svcPort.Port, lbEps.IPs, lbEps.Port); err != nil {
+			for _, extIP := range svc.Spec.ExternalIPs {
+				family := ipFamily(extIP)
+				if err := ovn.createPerNodeVIPs([]string{extIP}, svcPort.Protocol, svcPort.Port, lbEps.IPs(family), lbEps.Port, family); err != nil {
 					klog.Errorf("Error in creating ExternalIP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
 				}
 			}
@@ -132,10 +166,19 @@ func (ovn *Controller) AddEndpoints(ep *kapi.Endpoints, addClusterLBs bool) erro
 				if ing.IP == "" {
 					continue
 				}
-				if err := ovn.createPerNodeVIPs([]string{ing.IP}, svcPort.Protocol, svcPort.Port, lbEps.IPs, lbEps.Port); err != nil {
+				family := ipFamily(ing.IP)
+				if err := ovn.createPerNodeVIPs([]string{ing.IP}, svcPort.Protocol, svcPort.Port, lbEps.IPs(family), lbEps.Port, family); err != nil {
 					klog.Errorf("Error in creating Ingress LB IP for svc %s, target port: %d - %v\n", svc.Name, lbEps.Port, err)
 				}
 			}
+
+			if err := ovn.ensureLoadBalancerHealthChecks(svc, svcPort, lbEps); err != nil {
+				klog.Errorf("Error reconciling health checks for svc %s, port: %d - %v", svc.Name, svcPort.Port, err)
+			}
+
+			if err := ovn.reconcileServiceAffinity(svc, svcPort); err != nil {
+				klog.Errorf("Error reconciling session affinity for svc %s, port: %d - %v", svc.Name, svcPort.Port, err)
+			}
 		}
 	}
 	return nil
@@ -153,6 +196,10 @@ func (ovn *Controller) handleNodePortLB(node *kapi.Node) error {
 	}
 	// END OCP HACK
 
+	if _, err := ovn.ensureNodeLBEndpoint(node.Name); err != nil {
+		return fmt.Errorf("failed to ensure LB endpoint for node %q: %v", node.Name, err)
+	}
+
 	// if new services controller run a full sync on all services
 	// services that have host network endpoints, are nodeport, external IP or ingress all have unique
 	// per-node load balancers. Since we cannot determine which services those are without significant parsing
@@ -161,6 +208,11 @@ func (ovn *Controller) handleNodePortLB(node *kapi.Node) error {
 		if err := ovn.svcController.RequestFullSync(); err != nil {
 			return err
 		}
+		// The full sync above repopulates this node's VIPs asynchronously via
+		// the LB endpoint, so draining the legacy gateway/worker VIPs here
+		// would leave the node serving nothing until it completes. Leave the
+		// legacy drain to the synchronous path below until the new
+		// controller exposes a way to run it only after sync finishes.
 		return nil
 	}
 	// Legacy controller code
@@ -182,6 +234,14 @@ func (ovn *Controller) handleNodePortLB(node *kapi.Node) error {
 
 		}
 	}
+
+	// One-time migration: now that this node's LB endpoint has been populated
+	// by the AddEndpoints calls above, any VIPs still written directly into
+	// its gateway/worker load balancers from before this upgrade are
+	// redundant and safe to drop.
+	if err := ovn.drainLegacyPerNodeVIPs(node); err != nil {
+		klog.Errorf("Failed to drain legacy per-node VIPs for node %q: %v", node.Name, err)
+	}
 	return nil
 }
 
@@ -211,16 +271,27 @@ func (ovn *Controller) deleteEndpoints(ep *kapi.Endpoints) error {
 		klog.Error(err)
 	}
 
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+
 	for _, svcPort := range svc.Spec.Ports {
-		clusterLB, err := ovn.getLoadBalancer(svcPort.Protocol)
-		if err != nil {
-			klog.Errorf("Failed to get load balancer for %s (%v)", clusterLB, err)
-			continue
+		// Cluster IP service: each ClusterIP only ever lived on the load
+		// balancer matching its own address family.
+		for _, clusterIP := range clusterIPs {
+			clusterLB, err := ovn.ensureServiceClusterLoadBalancer(svc, svcPort.Protocol, ipFamily(clusterIP), getAffinitySpec(svc))
+			if err != nil {
+				klog.Errorf("Failed to get load balancer for %s (%v)", svcPort.Protocol, err)
+				continue
+			}
+			if err := ovn.configureLoadBalancer(clusterLB, clusterIP, svcPort.Port, nil); err != nil {
+				klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", clusterLB, clusterIP, svcPort.Port, err)
+			}
 		}
-		// Cluster IP service
-		err = ovn.configureLoadBalancer(clusterLB, svc.Spec.ClusterIP, svcPort.Port, nil)
-		if err != nil {
-			klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", clusterLB, svc.Spec.ClusterIP, svcPort.Port, err)
+
+		if err := ovn.deleteLoadBalancerHealthChecks(svc, svcPort); err != nil {
+			klog.Errorf("Error deleting health checks for svc %s, port: %d - %v", svc.Name, svcPort.Port, err)
 		}
 
 		for _, gateway := range gateways {
@@ -231,9 +302,10 @@ func (ovn *Controller) deleteEndpoints(ep *kapi.Endpoints) error {
 			}
 			// ClusterIP may be on gateway or worker LBs, so need to remove here as well
 			if config.Gateway.Mode == config.GatewayModeShared {
-				err = ovn.configureLoadBalancer(gatewayLB, svc.Spec.ClusterIP, svcPort.Port, nil)
-				if err != nil {
-					klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", gatewayLB, svc.Spec.ClusterIP, svcPort.Port, err)
+				for _, clusterIP := range clusterIPs {
+					if err := ovn.configureLoadBalancer(gatewayLB, clusterIP, svcPort.Port, nil); err != nil {
+						klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", gatewayLB, clusterIP, svcPort.Port, err)
+					}
 				}
 			}
 			workerNode := util.GetWorkerFromGatewayRouter(gateway)
@@ -243,9 +315,10 @@ func (ovn *Controller) deleteEndpoints(ep *kapi.Endpoints) error {
 				continue
 			}
 			if config.Gateway.Mode == config.GatewayModeShared {
-				err = ovn.configureLoadBalancer(workerLB, svc.Spec.ClusterIP, svcPort.Port, nil)
-				if err != nil {
-					klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", workerLB, svc.Spec.ClusterIP, svcPort.NodePort, err)
+				for _, clusterIP := range clusterIPs {
+					if err := ovn.configureLoadBalancer(workerLB, clusterIP, svcPort.Port, nil); err != nil {
+						klog.Errorf("Error in configuring loadbalancer for lb %s - %s - %d: %v", workerLB, clusterIP, svcPort.NodePort, err)
+					}
 				}
 			}
 
@@ -297,11 +370,20 @@ func (ovn *Controller) deleteEndpoints(ep *kapi.Endpoints) error {
 	return nil
 }
 
-// hasHostEndpoints determines if a slice of endpoints contains a host networked pod
+// hasHostEndpoints determines if a slice of endpoints contains a host networked
+// pod. endpointIPs is expected to already be filtered to a single address
+// family (see lbEndpoints.IPs); each endpoint is only checked against
+// ClusterSubnets entries of its own family. An endpoint whose family has no
+// ClusterSubnets entry at all (e.g. a v6 endpoint in a cluster with only a v4
+// pod CIDR) matches nothing and is therefore treated as host-networked.
 func hasHostEndpoints(endpointIPs []string) bool {
 	for _, endpointIP := range endpointIPs {
+		family := ipFamily(endpointIP)
 		found := false
 		for _, clusterNet := range config.Default.ClusterSubnets {
+			if ipFamily(clusterNet.CIDR.IP.String()) != family {
+				continue
+			}
 			if clusterNet.CIDR.Contains(net.ParseIP(endpointIP)) {
 				found = true
 				break
@@ -0,0 +1,58 @@
+package ovn
+
+import (
+	"testing"
+
+	kapi "k8s.io/api/core/v1"
+)
+
+func TestGetLbEndpointsDualStack(t *testing.T) {
+	ovn := &Controller{}
+	ep := &kapi.Endpoints{
+		Subsets: []kapi.EndpointSubset{
+			{
+				Addresses: []kapi.EndpointAddress{
+					{IP: "10.244.0.5"},
+					{IP: "fd00:10:244::5"},
+				},
+				Ports: []kapi.EndpointPort{
+					{Name: "http", Port: 8080, Protocol: kapi.ProtocolTCP},
+				},
+			},
+		},
+	}
+
+	protoPortMap := ovn.getLbEndpoints(ep)
+	lbEps, ok := protoPortMap[kapi.ProtocolTCP]["http"]
+	if !ok {
+		t.Fatalf("expected a TCP/http entry in the protocol map")
+	}
+	if lbEps.Port != 8080 {
+		t.Errorf("got port %d, want 8080", lbEps.Port)
+	}
+
+	v4 := lbEps.IPs(kapi.IPv4Protocol)
+	if len(v4) != 1 || v4[0] != "10.244.0.5" {
+		t.Errorf("got v4 backends %v, want [10.244.0.5]", v4)
+	}
+
+	v6 := lbEps.IPs(kapi.IPv6Protocol)
+	if len(v6) != 1 || v6[0] != "fd00:10:244::5" {
+		t.Errorf("got v6 backends %v, want [fd00:10:244::5]", v6)
+	}
+}
+
+func TestIPFamily(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want kapi.IPFamily
+	}{
+		{"10.96.0.1", kapi.IPv4Protocol},
+		{"fd00::1", kapi.IPv6Protocol},
+	}
+	for _, tt := range tests {
+		if got := ipFamily(tt.ip); got != tt.want {
+			t.Errorf("ipFamily(%q) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package ovn
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/util"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// lbHealthCheckAnnotation lets a Service opt a load-balancer backend set into
+	// OVN active health checking instead of relying solely on kubelet readiness
+	// probes to update the Endpoints object.
+	lbHealthCheckAnnotation = "k8s.ovn.org/lb-health-check"
+
+	defaultHealthCheckInterval     = 5 * time.Second
+	defaultHealthCheckTimeout      = 3 * time.Second
+	defaultHealthCheckSuccessCount = 2
+	defaultHealthCheckFailureCount = 3
+)
+
+// lbHealthCheckSpec mirrors the tunables of an OVN Load_Balancer_Health_Check row.
+type lbHealthCheckSpec struct {
+	Interval     time.Duration `json:"interval,omitempty"`
+	Timeout      time.Duration `json:"timeout,omitempty"`
+	SuccessCount int           `json:"successCount,omitempty"`
+	FailureCount int           `json:"failureCount,omitempty"`
+}
+
+// parseLBHealthCheckSpec reads the lbHealthCheckAnnotation off a Service, if present,
+// filling in defaults for any field the user left unset. A missing annotation is not
+// an error; it simply means health checking is disabled for that service.
+func parseLBHealthCheckSpec(annotations map[string]string) (*lbHealthCheckSpec, error) {
+	raw, ok := annotations[lbHealthCheckAnnotation]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+
+	spec := &lbHealthCheckSpec{
+		Interval:     defaultHealthCheckInterval,
+		Timeout:      defaultHealthCheckTimeout,
+		SuccessCount: defaultHealthCheckSuccessCount,
+		FailureCount: defaultHealthCheckFailureCount,
+	}
+
+	var overrides struct {
+		IntervalSeconds int `json:"intervalSeconds"`
+		TimeoutSeconds  int `json:"timeoutSeconds"`
+		SuccessCount    int `json:"successCount"`
+		FailureCount    int `json:"failureCount"`
+	}
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %v", lbHealthCheckAnnotation, raw, err)
+	}
+	if overrides.IntervalSeconds > 0 {
+		spec.Interval = time.Duration(overrides.IntervalSeconds) * time.Second
+	}
+	if overrides.TimeoutSeconds > 0 {
+		spec.Timeout = time.Duration(overrides.TimeoutSeconds) * time.Second
+	}
+	if overrides.SuccessCount > 0 {
+		spec.SuccessCount = overrides.SuccessCount
+	}
+	if overrides.FailureCount > 0 {
+		spec.FailureCount = overrides.FailureCount
+	}
+	return spec, nil
+}
+
+// healthCheckOwnerKey identifies which Service/port a Load_Balancer_Health_Check
+// row belongs to. getLoadBalancer returns one Load_Balancer row shared by every
+// ClusterIP service of a given (protocol, family), so the owner can't be inferred
+// from the load balancer the row happens to live on; it's stamped as an
+// external_id at creation time instead and used to scope lookups/GC to this
+// service/port alone.
+func healthCheckOwnerKey(svc *kapi.Service, svcPort kapi.ServicePort) string {
+	return fmt.Sprintf("%s/%s:%d", svc.Namespace, svc.Name, svcPort.Port)
+}
+
+// ensureLoadBalancerHealthChecks reconciles the Load_Balancer_Health_Check rows and
+// Service_Monitor VIPs for one (protocol, port) backend set of svc against the
+// current lbEndpoints. If the service does not request health checking, any
+// previously-created rows are torn down instead.
+func (ovn *Controller) ensureLoadBalancerHealthChecks(svc *kapi.Service, svcPort kapi.ServicePort, lbEps lbEndpoints) error {
+	spec, err := parseLBHealthCheckSpec(svc.Annotations)
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		return ovn.deleteLoadBalancerHealthChecks(svc, svcPort)
+	}
+
+	owner := healthCheckOwnerKey(svc, svcPort)
+
+	for family, backendIPs := range lbEps.IPsByFamily {
+		loadBalancer, err := ovn.getLoadBalancer(svcPort.Protocol, family)
+		if err != nil {
+			klog.Errorf("Failed to get %s load balancer for %s: %v", family, svcPort.Protocol, err)
+			continue
+		}
+
+		for _, backendIP := range backendIPs {
+			vip := util.JoinHostPortInt32(backendIP, lbEps.Port)
+
+			smUUID, err := ovn.ensureServiceMonitor(backendIP, lbEps.Port, svcPort.Protocol)
+			if err != nil {
+				klog.Errorf("Failed to ensure Service_Monitor for %s: %v", vip, err)
+				continue
+			}
+
+			if err := ovn.ensureLoadBalancerHealthCheck(loadBalancer, vip, owner, spec, smUUID); err != nil {
+				klog.Errorf("Failed to ensure Load_Balancer_Health_Check for %s on %s: %v", vip, loadBalancer, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureServiceMonitor returns the UUID of the Service_Monitor row probing
+// backendIP:port, creating it if it doesn't already exist.
+func (ovn *Controller) ensureServiceMonitor(backendIP string, port int32, protocol kapi.Protocol) (string, error) {
+	logicalPort := util.JoinHostPortInt32(backendIP, port)
+
+	if existing, _, err := util.RunOVNSbctl("--data=bare", "--no-heading", "--columns=_uuid", "find",
+		"Service_Monitor", fmt.Sprintf("logical_port=%s", logicalPort)); err == nil && existing != "" {
+		return existing, nil
+	}
+
+	uuid, stderr, err := util.RunOVNSbctl(
+		"--", "--id=@sm", "create", "Service_Monitor",
+		fmt.Sprintf("logical_port=%s", logicalPort),
+		fmt.Sprintf("ip=%s", backendIP),
+		fmt.Sprintf("port=%d", port),
+		fmt.Sprintf("protocol=%s", protocol),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Service_Monitor for %s: stderr: %q, err: %v", logicalPort, stderr, err)
+	}
+	return uuid, nil
+}
+
+// ensureLoadBalancerHealthCheck creates (or updates) the Load_Balancer_Health_Check
+// row for vip and attaches it to loadBalancer. serviceMonitorUUID is stamped onto
+// the row as external_ids:service_monitor so deleteLoadBalancerHealthCheck can
+// later find and destroy the matching Service_Monitor row without guessing at its
+// identity; owner and loadBalancer are likewise stamped as external_ids so GC can
+// find and remove exactly this service/port's rows without touching any other
+// service's health checks on the same (shared) load balancer.
+func (ovn *Controller) ensureLoadBalancerHealthCheck(loadBalancer, vip, owner string, spec *lbHealthCheckSpec, serviceMonitorUUID string) error {
+	options := []string{
+		fmt.Sprintf("options:interval=%d", int(spec.Interval.Seconds())),
+		fmt.Sprintf("options:timeout=%d", int(spec.Timeout.Seconds())),
+		fmt.Sprintf("options:success_count=%d", spec.SuccessCount),
+		fmt.Sprintf("options:failure_count=%d", spec.FailureCount),
+		fmt.Sprintf("external_ids:service_monitor=%s", serviceMonitorUUID),
+		fmt.Sprintf("external_ids:owner=%s", owner),
+		fmt.Sprintf("external_ids:load_balancer=%s", loadBalancer),
+	}
+
+	existing, _, err := util.RunOVNNbctl("--data=bare", "--no-heading", "--columns=_uuid", "find",
+		"Load_Balancer_Health_Check", fmt.Sprintf("vip=%s", vip), fmt.Sprintf("external_ids:owner=%s", owner))
+	if err == nil && existing != "" {
+		args := append([]string{"set", "Load_Balancer_Health_Check", existing}, options...)
+		if _, stderr, err := util.RunOVNNbctl(args...); err != nil {
+			return fmt.Errorf("failed to update Load_Balancer_Health_Check %s: stderr: %q, err: %v", vip, stderr, err)
+		}
+		return nil
+	}
+
+	createArgs := append([]string{"--", "--id=@hc", "create", "Load_Balancer_Health_Check", fmt.Sprintf("vip=%s", vip)}, options...)
+	createArgs = append(createArgs, "--", "add", "Load_Balancer", loadBalancer, "health_check", "@hc")
+	if _, stderr, err := util.RunOVNNbctl(createArgs...); err != nil {
+		return fmt.Errorf("failed to create Load_Balancer_Health_Check %s on %s: stderr: %q, err: %v", vip, loadBalancer, stderr, err)
+	}
+	return nil
+}
+
+// deleteLoadBalancerHealthChecks removes only the Load_Balancer_Health_Check and
+// Service_Monitor rows this controller created for svc/svcPort specifically, e.g.
+// because the service stopped requesting health checking or is being torn down
+// entirely. getLoadBalancer's Load_Balancer row is shared by every ClusterIP
+// service of a (protocol, family), so this must never reach for "every health
+// check on the load balancer" -- that would also delete unrelated services'
+// health checks. Rows are found by the owner external_id stamped on them in
+// ensureLoadBalancerHealthCheck, independent of which shared load balancer they
+// happen to be attached to.
+func (ovn *Controller) deleteLoadBalancerHealthChecks(svc *kapi.Service, svcPort kapi.ServicePort) error {
+	owner := healthCheckOwnerKey(svc, svcPort)
+
+	healthChecks, stderr, err := util.RunOVNNbctl("--data=bare", "--no-heading", "--columns=_uuid", "find",
+		"Load_Balancer_Health_Check", fmt.Sprintf("external_ids:owner=%s", owner))
+	if err != nil {
+		return fmt.Errorf("failed to find health checks owned by %s: stderr: %q, err: %v", owner, stderr, err)
+	}
+
+	for _, hc := range util.SplitOVNUUIDs(healthChecks) {
+		if err := ovn.deleteLoadBalancerHealthCheck(hc); err != nil {
+			klog.Errorf("Failed to remove Load_Balancer_Health_Check %s owned by %s: %v", hc, owner, err)
+		}
+	}
+	return nil
+}
+
+// deleteLoadBalancerHealthCheck removes a single Load_Balancer_Health_Check row
+// and its correlated Service_Monitor, detaching it from whichever load balancer
+// it was stamped with at creation time (external_ids:load_balancer) rather than
+// from every load balancer that might reference it.
+func (ovn *Controller) deleteLoadBalancerHealthCheck(hc string) error {
+	loadBalancer, _, err := util.RunOVNNbctl("get", "Load_Balancer_Health_Check", hc, "external_ids:load_balancer")
+	if err != nil || loadBalancer == "" {
+		return fmt.Errorf("failed to determine owning load balancer for Load_Balancer_Health_Check %s: %v", hc, err)
+	}
+	smUUID, _, err := util.RunOVNNbctl("get", "Load_Balancer_Health_Check", hc, "external_ids:service_monitor")
+	if err != nil {
+		smUUID = ""
+	}
+
+	if _, _, err := util.RunOVNNbctl("--", "--if-exists", "remove", "Load_Balancer", loadBalancer, "health_check", hc,
+		"--", "--if-exists", "destroy", "Load_Balancer_Health_Check", hc); err != nil {
+		return fmt.Errorf("failed to remove Load_Balancer_Health_Check %s from %s: %v", hc, loadBalancer, err)
+	}
+	if smUUID == "" {
+		return nil
+	}
+	if _, _, err := util.RunOVNSbctl("--if-exists", "destroy", "Service_Monitor", smUUID); err != nil {
+		klog.Errorf("Failed to remove stale Service_Monitor %s: %v", smUUID, err)
+	}
+	return nil
+}
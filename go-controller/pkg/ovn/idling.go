@@ -0,0 +1,210 @@
+package ovn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// wakeupTTL bounds how long an in-flight wakeup is tracked before it is aged
+// out, so a service that never comes back up doesn't pin memory forever.
+const wakeupTTL = 2 * time.Minute
+
+// IdleEvent is a translation of an OVN SB `empty_lb_backends` Controller_Event
+// row into something callers outside this package can reason about without
+// knowing OVSDB schema details.
+type IdleEvent struct {
+	Namespace string
+	Service   string
+	Protocol  kapi.Protocol
+	VIP       string
+}
+
+// IdleEventSink is notified whenever OVN reports that traffic hit a load
+// balancer VIP with zero backends. Implementations turn that into whatever
+// scale-from-zero signal their autoscaler expects.
+type IdleEventSink interface {
+	HandleIdleEvent(event IdleEvent) error
+}
+
+// needsWakeupCondition is the Service annotation key the default IdleEventSink
+// sets to "true" so that HPA/KEDA-style scalers (or anything else watching Services) can
+// react to a wakeup request without parsing Kubernetes Events.
+const needsWakeupCondition = "k8s.ovn.org/needs-wakeup"
+
+// defaultIdleEventSink is the built-in IdleEventSink: it annotates the Service
+// with a needs-wakeup condition and emits a Kubernetes Event. It also tracks
+// each service's first wakeup time in inFlightWakeup; ageOutWakeups drops
+// those entries after wakeupTTL. Neither removes the needs-wakeup annotation.
+type defaultIdleEventSink struct {
+	kubeClient     kubernetes.Interface
+	recorder       record.EventRecorder
+	mu             sync.Mutex
+	inFlightWakeup map[string]time.Time // key: namespace/service -> time first seen
+}
+
+// newDefaultIdleEventSink builds the default IdleEventSink. It takes a
+// kubeClient directly (rather than reaching through a *Controller), so the
+// sink and its Service annotation write can be built without a Controller.
+func newDefaultIdleEventSink(kubeClient kubernetes.Interface, recorder record.EventRecorder) *defaultIdleEventSink {
+	return &defaultIdleEventSink{
+		kubeClient:     kubeClient,
+		recorder:       recorder,
+		inFlightWakeup: make(map[string]time.Time),
+	}
+}
+
+// NewDefaultIdleEventSink returns the built-in IdleEventSink implementation,
+// for callers wiring up WatchEmptyLBBackendEvents.
+func NewDefaultIdleEventSink(kubeClient kubernetes.Interface, recorder record.EventRecorder) IdleEventSink {
+	return newDefaultIdleEventSink(kubeClient, recorder)
+}
+
+func wakeupKey(namespace, service string) string {
+	return namespace + "/" + service
+}
+
+// HandleIdleEvent implements IdleEventSink.
+func (d *defaultIdleEventSink) HandleIdleEvent(event IdleEvent) error {
+	key := wakeupKey(event.Namespace, event.Service)
+
+	d.mu.Lock()
+	if _, exists := d.inFlightWakeup[key]; !exists {
+		d.inFlightWakeup[key] = time.Now()
+	}
+	d.mu.Unlock()
+
+	svc, err := d.kubeClient.CoreV1().Services(event.Namespace).Get(context.TODO(), event.Service, metav1.GetOptions{})
+	if err != nil {
+		// Service may have been deleted since the event fired; nothing to wake up.
+		klog.V(5).Infof("Idle event for %s has no matching service: %v", key, err)
+		return nil
+	}
+
+	if svc.Annotations[needsWakeupCondition] != "true" {
+		updated := svc.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[needsWakeupCondition] = "true"
+		if _, err := d.kubeClient.CoreV1().Services(event.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to set %s annotation on service %s: %v", needsWakeupCondition, key, err)
+		}
+	}
+
+	if d.recorder != nil {
+		d.recorder.Eventf(svc, kapi.EventTypeNormal, "NeedsWakeup",
+			"Traffic to %s (%s) arrived at a load balancer with zero endpoints", event.VIP, event.Protocol)
+	}
+
+	klog.Infof("Service %s/%s needs wakeup: condition %s set for VIP %s", event.Namespace, event.Service, needsWakeupCondition, event.VIP)
+	return nil
+}
+
+// ageOutWakeups drops any in-flight wakeup entries older than wakeupTTL so
+// entries for services that never come back up are not kept forever. It does
+// not affect HandleIdleEvent, which annotates and emits an event either way.
+func (d *defaultIdleEventSink) ageOutWakeups(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, seen := range d.inFlightWakeup {
+		if now.Sub(seen) > wakeupTTL {
+			delete(d.inFlightWakeup, key)
+		}
+	}
+}
+
+// clearWakeup drops the in-flight wakeup entry for a service, e.g. once
+// AddEndpoints observes it has real backends again.
+func (d *defaultIdleEventSink) clearWakeup(namespace, service string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlightWakeup, wakeupKey(namespace, service))
+}
+
+// parseEmptyLBBackendsEvent translates the raw SB Controller_Event fields for an
+// `empty_lb_backends` event into an IdleEvent. The vip_name format OVN uses is
+// "<namespace>/<service>:<protocol>:<vip>", matching the naming used when the
+// VIP's external_ids were set on the Load_Balancer row.
+func parseEmptyLBBackendsEvent(eventType, vipName string) (IdleEvent, bool) {
+	if eventType != "empty_lb_backends" {
+		return IdleEvent{}, false
+	}
+	nsName, rest, found := strings.Cut(vipName, ":")
+	if !found {
+		return IdleEvent{}, false
+	}
+	protocol, vip, found := strings.Cut(rest, ":")
+	if !found {
+		return IdleEvent{}, false
+	}
+	namespace, service, found := strings.Cut(nsName, "/")
+	if !found {
+		return IdleEvent{}, false
+	}
+	return IdleEvent{
+		Namespace: namespace,
+		Service:   service,
+		Protocol:  kapi.Protocol(protocol),
+		VIP:       vip,
+	}, true
+}
+
+// WatchServiceIdling is the entry point a controller's startup path calls to
+// turn the OVNEmptyLbEvents config flag into a running scale-from-zero
+// consumer: it builds the default IdleEventSink against kubeClient/recorder
+// and feeds it from events until stopCh closes.
+func (ovn *Controller) WatchServiceIdling(kubeClient kubernetes.Interface, recorder record.EventRecorder, events <-chan OVNControllerEvent, stopCh <-chan struct{}) {
+	sink := NewDefaultIdleEventSink(kubeClient, recorder)
+	ovn.WatchEmptyLBBackendEvents(sink, events, stopCh)
+}
+
+// WatchEmptyLBBackendEvents reads Controller_Event rows from events and feeds
+// every `empty_lb_backends` event it sees to sink, until stopCh or events is
+// closed. It does not watch the SB database itself; the caller supplies events.
+// This is what turns the latent OVNEmptyLbEvents flag into a usable
+// scale-from-zero integration point.
+func (ovn *Controller) WatchEmptyLBBackendEvents(sink IdleEventSink, events <-chan OVNControllerEvent, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(wakeupTTL / 2)
+	defer ticker.Stop()
+
+	ager, _ := sink.(interface{ ageOutWakeups(time.Time) })
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if ager != nil {
+				ager.ageOutWakeups(time.Now())
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			idleEvent, ok := parseEmptyLBBackendsEvent(ev.EventType, ev.VIPName)
+			if !ok {
+				continue
+			}
+			if err := sink.HandleIdleEvent(idleEvent); err != nil {
+				klog.Errorf("Failed to handle idle event for %s/%s: %v", idleEvent.Namespace, idleEvent.Service, err)
+			}
+		}
+	}
+}
+
+// OVNControllerEvent is the subset of an SB Controller_Event row this package
+// cares about.
+type OVNControllerEvent struct {
+	UUID      string
+	EventType string
+	VIPName   string
+}
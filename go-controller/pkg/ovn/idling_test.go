@@ -0,0 +1,100 @@
+package ovn
+
+import (
+	"testing"
+	"time"
+
+	kapi "k8s.io/api/core/v1"
+)
+
+func TestParseEmptyLBBackendsEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		vipName   string
+		wantOK    bool
+		want      IdleEvent
+	}{
+		{
+			name:      "well formed TCP event",
+			eventType: "empty_lb_backends",
+			vipName:   "default/my-svc:TCP:10.96.0.1:80",
+			wantOK:    true,
+			want: IdleEvent{
+				Namespace: "default",
+				Service:   "my-svc",
+				Protocol:  kapi.ProtocolTCP,
+				VIP:       "10.96.0.1:80",
+			},
+		},
+		{
+			name:      "ignores unrelated event types",
+			eventType: "empty_lb_backends_unrelated",
+			vipName:   "default/my-svc:TCP:10.96.0.1:80",
+			wantOK:    false,
+		},
+		{
+			name:      "missing vip separator",
+			eventType: "empty_lb_backends",
+			vipName:   "default/my-svc-TCP-10.96.0.1-80",
+			wantOK:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseEmptyLBBackendsEvent(tt.eventType, tt.vipName)
+			if ok != tt.wantOK {
+				t.Fatalf("parseEmptyLBBackendsEvent() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseEmptyLBBackendsEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeIdleEventSink records every IdleEvent handed to it, simulating a scaler
+// that watches for wakeup signals.
+type fakeIdleEventSink struct {
+	received []IdleEvent
+}
+
+func (f *fakeIdleEventSink) HandleIdleEvent(event IdleEvent) error {
+	f.received = append(f.received, event)
+	return nil
+}
+
+func TestWatchEmptyLBBackendEvents(t *testing.T) {
+	sink := &fakeIdleEventSink{}
+	events := make(chan OVNControllerEvent, 2)
+	stopCh := make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ovn := &Controller{}
+		ovn.WatchEmptyLBBackendEvents(sink, events, stopCh)
+	}()
+
+	events <- OVNControllerEvent{EventType: "empty_lb_backends", VIPName: "ns/svc:UDP:10.96.0.5:53"}
+	events <- OVNControllerEvent{EventType: "some_other_event", VIPName: "ignored"}
+	close(events)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("WatchEmptyLBBackendEvents did not return after events channel closed")
+	}
+
+	if len(sink.received) != 1 {
+		t.Fatalf("expected exactly 1 idle event, got %d: %+v", len(sink.received), sink.received)
+	}
+	want := IdleEvent{Namespace: "ns", Service: "svc", Protocol: kapi.ProtocolUDP, VIP: "10.96.0.5:53"}
+	if sink.received[0] != want {
+		t.Errorf("got idle event %+v, want %+v", sink.received[0], want)
+	}
+}
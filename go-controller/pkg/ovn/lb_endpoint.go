@@ -0,0 +1,277 @@
+package ovn
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/ovn/loadbalancer"
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/types"
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/util"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// lbEndpointPrefix names the dedicated logical switch port each node gets to
+// terminate the aliased service VIPs programmed against it, analogous to the
+// libnetwork LB-sandbox design: one port per node instead of one VIP copy per
+// gateway/worker load balancer.
+const lbEndpointPrefix = "lb-endpoint-"
+
+// nodeLBEndpoint is the single logical port a node's NodePort/ExternalIP/Ingress/
+// hostNetwork VIPs are aliased onto, replacing the full per-node/per-LB VIP mesh.
+// The port's dynamic_addresses column is "<mac> [ip4] [ip6]" on a dual-stack
+// switch, so the allocated address is tracked per family rather than as one
+// opaque string.
+type nodeLBEndpoint struct {
+	NodeName string
+	PortName string
+	IPv4     string
+	IPv6     string
+}
+
+// IP returns the endpoint's address for family, or "" if none was allocated.
+func (e *nodeLBEndpoint) IP(family kapi.IPFamily) string {
+	if family == kapi.IPv6Protocol {
+		return e.IPv6
+	}
+	return e.IPv4
+}
+
+// parseDynamicAddresses splits a logical_switch_port dynamic_addresses value
+// ("<mac> <ip4> <ip6>", with either IP omitted) into its IPv4/IPv6 addresses.
+func parseDynamicAddresses(dynamicAddresses string) (ipv4, ipv6 string) {
+	fields := strings.Fields(dynamicAddresses)
+	for _, field := range fields {
+		ip := net.ParseIP(field)
+		if ip == nil {
+			continue // the leading MAC address
+		}
+		if ip.To4() != nil {
+			ipv4 = field
+		} else {
+			ipv6 = field
+		}
+	}
+	return ipv4, ipv6
+}
+
+// ensureNodeLBEndpoint allocates (or returns the existing) LB endpoint port for a
+// node, giving it an address out of the node's own switch subnet.
+func (ovn *Controller) ensureNodeLBEndpoint(nodeName string) (*nodeLBEndpoint, error) {
+	portName := lbEndpointPrefix + nodeName
+	switchName := nodeName
+
+	dynamicAddresses, stderr, err := util.RunOVNNbctl("get", "logical_switch_port", portName, "dynamic_addresses")
+	if err != nil || dynamicAddresses == "" {
+		dynamicAddresses, stderr, err = util.RunOVNNbctl(
+			"--", "--may-exist", "lsp-add", switchName, portName,
+			"--", "lsp-set-addresses", portName, "dynamic",
+			"--", "set", "logical_switch_port", portName, fmt.Sprintf("external_ids:node=%s", nodeName),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create LB endpoint port %s on switch %s: stderr: %q, err: %v", portName, switchName, stderr, err)
+		}
+		dynamicAddresses, _, err = util.RunOVNNbctl("get", "logical_switch_port", portName, "dynamic_addresses")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read allocated address for LB endpoint port %s: %v", portName, err)
+		}
+	}
+
+	ipv4, ipv6 := parseDynamicAddresses(dynamicAddresses)
+	if ipv4 == "" && ipv6 == "" {
+		return nil, fmt.Errorf("no address allocated yet for LB endpoint port %s (dynamic_addresses: %q)", portName, dynamicAddresses)
+	}
+
+	return &nodeLBEndpoint{NodeName: nodeName, PortName: portName, IPv4: ipv4, IPv6: ipv6}, nil
+}
+
+// deleteNodeLBEndpoint removes a node's LB endpoint port; called when a node is
+// removed from the cluster. The node's LB endpoint load balancers (see
+// getNodeLBEndpointLoadBalancer) and the VIPs on them are not removed.
+func (ovn *Controller) deleteNodeLBEndpoint(nodeName string) error {
+	portName := lbEndpointPrefix + nodeName
+	if _, stderr, err := util.RunOVNNbctl("--if-exists", "lsp-del", portName); err != nil {
+		return fmt.Errorf("failed to delete LB endpoint port %s: stderr: %q, err: %v", portName, stderr, err)
+	}
+	return nil
+}
+
+// aliasVIPToLBEndpoint programs vipIP:port against a node's LB endpoint once,
+// instead of writing a copy of the VIP into every gateway router and worker
+// switch load balancer for that node. OVN SNATs backend replies to the LB
+// endpoint's address so they return through the same port. vipIP is typically
+// the LB endpoint's own address (NodePort), but may be any VIP that should be
+// reachable through this node (ExternalIP, LoadBalancer ingress IP, or a
+// ClusterIP backed by host-networked endpoints).
+func (ovn *Controller) aliasVIPToLBEndpoint(endpoint *nodeLBEndpoint, vipIP string, protocol kapi.Protocol, port int32, targetIPs []string, targetPort int32) error {
+	loadBalancer, err := ovn.getNodeLBEndpointLoadBalancer(endpoint, protocol)
+	if err != nil {
+		return err
+	}
+	vip := util.JoinHostPortInt32(vipIP, port)
+	if err := ovn.createLoadBalancerVIPs(loadBalancer, []string{vipIP}, port, targetIPs, targetPort); err != nil {
+		return fmt.Errorf("failed to alias vip %s to LB endpoint %s: %v", vip, endpoint.PortName, err)
+	}
+	snatIP := endpoint.IP(ipFamily(vipIP))
+	if snatIP == "" {
+		klog.Errorf("LB endpoint %s has no %s address to SNAT backend replies for vip %s", endpoint.PortName, ipFamily(vipIP), vip)
+		return nil
+	}
+	if _, stderr, err := util.RunOVNNbctl("set", "logical_switch_port", endpoint.PortName,
+		fmt.Sprintf("options:snat-ip=%s", snatIP)); err != nil {
+		klog.Errorf("Failed to set backend SNAT address on LB endpoint %s: stderr: %q, err: %v", endpoint.PortName, stderr, err)
+	}
+	return nil
+}
+
+// unaliasVIPFromLBEndpoint removes vipIP:port from a node's LB endpoint load
+// balancer.
+func (ovn *Controller) unaliasVIPFromLBEndpoint(endpoint *nodeLBEndpoint, vipIP string, protocol kapi.Protocol, port int32) error {
+	loadBalancer, err := ovn.getNodeLBEndpointLoadBalancer(endpoint, protocol)
+	if err != nil {
+		return err
+	}
+	vip := util.JoinHostPortInt32(vipIP, port)
+	if err := ovn.deleteLoadBalancerVIP(loadBalancer, vip); err != nil {
+		return fmt.Errorf("failed to remove vip %s from LB endpoint %s: %v", vip, endpoint.PortName, err)
+	}
+	return nil
+}
+
+// createPerNodeVIPs is the LB-endpoint-aware replacement for writing a copy of
+// vipIPs:port into every gateway router and worker switch load balancer: it
+// programs the VIP once against each node's single LB endpoint port instead.
+// A nil/empty vipIPs means NodePort, where the VIP is the node's own LB
+// endpoint address for family.
+func (ovn *Controller) createPerNodeVIPs(vipIPs []string, protocol kapi.Protocol, port int32, backendIPs []string, backendPort int32, family kapi.IPFamily) error {
+	nodes, err := ovn.watchFactory.GetNodes()
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range nodes {
+		endpoint, err := ovn.ensureNodeLBEndpoint(node.Name)
+		if err != nil {
+			klog.Errorf("Failed to ensure LB endpoint for node %q: %v", node.Name, err)
+			continue
+		}
+		vips := vipIPs
+		if len(vips) == 0 {
+			nodeIP := endpoint.IP(family)
+			if nodeIP == "" {
+				klog.Errorf("LB endpoint for node %q has no %s address to serve NodePort %d", node.Name, family, port)
+				continue
+			}
+			vips = []string{nodeIP}
+		}
+		for _, vip := range vips {
+			if err := ovn.aliasVIPToLBEndpoint(endpoint, vip, protocol, port, backendIPs, backendPort); err != nil {
+				klog.Errorf("Failed to alias vip %s on node %q: %v", vip, node.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteNodeVIPs removes vipIPs:port (or, if vipIPs is empty, the NodePort VIP
+// for family) from every node's LB endpoint.
+func (ovn *Controller) deleteNodeVIPs(vipIPs []string, protocol kapi.Protocol, port int32, family kapi.IPFamily) {
+	nodes, err := ovn.watchFactory.GetNodes()
+	if err != nil {
+		klog.Errorf("Failed to list nodes while deleting per-node VIPs: %v", err)
+		return
+	}
+	for _, node := range nodes {
+		endpoint, err := ovn.ensureNodeLBEndpoint(node.Name)
+		if err != nil {
+			klog.Errorf("Failed to ensure LB endpoint for node %q: %v", node.Name, err)
+			continue
+		}
+		vips := vipIPs
+		if len(vips) == 0 {
+			nodeIP := endpoint.IP(family)
+			if nodeIP == "" {
+				continue
+			}
+			vips = []string{nodeIP}
+		}
+		for _, vip := range vips {
+			if err := ovn.unaliasVIPFromLBEndpoint(endpoint, vip, protocol, port); err != nil {
+				klog.Errorf("Failed to remove vip %s on node %q: %v", vip, node.Name, err)
+			}
+		}
+	}
+}
+
+// getNodeLBEndpointLoadBalancer returns the single load balancer attached to a
+// node's LB endpoint port for the given protocol, creating it on first use.
+// NodePort/ExternalIP/LoadBalancer-ingress traffic enters the cluster at the
+// node's gateway router, not at its worker switch, so the load balancer is
+// attached to both: the worker switch (so the LB endpoint port itself, and any
+// pod on that switch, can reach the VIP) and the gateway router (so traffic
+// arriving from outside the cluster is actually routed to it).
+func (ovn *Controller) getNodeLBEndpointLoadBalancer(endpoint *nodeLBEndpoint, protocol kapi.Protocol) (string, error) {
+	lbName := fmt.Sprintf("%s%s_%s", types.ServiceLBPrefix, endpoint.NodeName, protocol)
+	gatewayRouter := types.GWRouterPrefix + endpoint.NodeName
+
+	lb, stderr, err := util.RunOVNNbctl("--data=bare", "--no-heading", "--columns=_uuid", "find",
+		"load_balancer", fmt.Sprintf("name=%s", lbName))
+	if err != nil {
+		return "", fmt.Errorf("failed to find LB endpoint load balancer %s: stderr: %q, err: %v", lbName, stderr, err)
+	}
+	if lb != "" {
+		// May-exist: a node that already has this load balancer from a prior
+		// reconcile may predate the gateway router wiring below.
+		if _, stderr, err := util.RunOVNNbctl("--may-exist", "lr-lb-add", gatewayRouter, lbName); err != nil {
+			return "", fmt.Errorf("failed to attach LB endpoint load balancer %s to gateway router %s: stderr: %q, err: %v", lbName, gatewayRouter, stderr, err)
+		}
+		return lbName, nil
+	}
+	if _, stderr, err := util.RunOVNNbctl(
+		"--", "create", "load_balancer", fmt.Sprintf("name=%s", lbName), fmt.Sprintf("protocol=%s", protocol),
+		"--", "ls-lb-add", endpoint.NodeName, lbName,
+		"--", "lr-lb-add", gatewayRouter, lbName,
+	); err != nil {
+		return "", fmt.Errorf("failed to create LB endpoint load balancer %s: stderr: %q, err: %v", lbName, stderr, err)
+	}
+	return lbName, nil
+}
+
+// drainLegacyPerNodeVIPs is run once per node during upgrade to remove VIPs that
+// were previously written directly into every gateway router and worker switch
+// load balancer, now that they are served through the node's LB endpoint instead.
+func (ovn *Controller) drainLegacyPerNodeVIPs(node *kapi.Node) error {
+	gatewayRouter := types.GWRouterPrefix + node.Name
+	workerSwitch := node.Name
+
+	for _, protocol := range []kapi.Protocol{kapi.ProtocolTCP, kapi.ProtocolUDP, kapi.ProtocolSCTP} {
+		gatewayLB, err := ovn.getGatewayLoadBalancer(gatewayRouter, protocol)
+		if err != nil {
+			klog.V(5).Infof("No legacy gateway load balancer to drain for %s/%s: %v", gatewayRouter, protocol, err)
+			continue
+		}
+		if err := ovn.clearLegacyVIPs(gatewayLB); err != nil {
+			klog.Errorf("Failed to drain legacy VIPs from gateway load balancer %s: %v", gatewayLB, err)
+		}
+
+		workerLB, err := loadbalancer.GetWorkerLoadBalancer(workerSwitch, protocol)
+		if err != nil {
+			klog.V(5).Infof("No legacy worker load balancer to drain for %s/%s: %v", workerSwitch, protocol, err)
+			continue
+		}
+		if err := ovn.clearLegacyVIPs(workerLB); err != nil {
+			klog.Errorf("Failed to drain legacy VIPs from worker load balancer %s: %v", workerLB, err)
+		}
+	}
+	return nil
+}
+
+// clearLegacyVIPs removes every vips entry from a gateway/worker load balancer,
+// now that those VIPs are served by the node's LB endpoint instead.
+func (ovn *Controller) clearLegacyVIPs(loadBalancer string) error {
+	if _, stderr, err := util.RunOVNNbctl("clear", "load_balancer", loadBalancer, "vips"); err != nil {
+		return fmt.Errorf("failed to clear vips on %s: stderr: %q, err: %v", loadBalancer, stderr, err)
+	}
+	return nil
+}